@@ -2,6 +2,23 @@
 //
 // It handles actions from `jobs.<job_id>.steps[*].uses` in the format of `owner/repo@v1234` hosted on GitHub,
 // which is sufficient for many workflows.
+//
+// The original version tag is kept as a trailing comment next to the pinned SHA, e.g. `owner/repo@<sha> # v1234`,
+// so the diff stays readable and the tag can be recovered later.
+//
+// The `-mode` flag controls what happens to each `uses:` value: `pin` (the default) replaces a version tag
+// with a SHA, `update` re-pins an already-pinned SHA to the latest release matching the original major
+// version, and `unpin` reverses a pin back to the tag recorded in its trailing comment.
+//
+// The workflows directory is walked recursively, so reusable workflows and composite actions in nested
+// directories are picked up too. `-include` and `-exclude` take comma-separated doublestar-style globs
+// (matched against the path relative to `-workflows-directory`) to narrow down which files are considered;
+// a file is only touched if its top-level YAML actually looks like a workflow or action (a `jobs:` or
+// `runs:` key), so unrelated YAML under `.github/` is left alone.
+//
+// Use `-app-id`, `-app-private-key-file` and `-installation-id` to authenticate as a GitHub App
+// installation instead of `GITHUB_TOKEN`, and `-github-base-url` to target a GitHub Enterprise
+// Server instance.
 package main
 
 import (
@@ -9,49 +26,181 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io/fs"
 	"log"
 	"os"
-	"path"
+	"path/filepath"
 	"regexp"
+	"strings"
 
-	"github.com/die-net/lrucache"
-	"github.com/gofri/go-github-ratelimit/github_ratelimit"
+	"github.com/bmatcuk/doublestar/v4"
 	"github.com/google/go-github/v70/github"
-	"github.com/gregjones/httpcache"
 	"gopkg.in/yaml.v3"
+
+	"go.eigsys.de/musikant/internal/ghclient"
 )
 
-var usesExpression = regexp.MustCompile("^([a-zA-Z0-9_.-]+)/([a-zA-Z0-9_.-]+)@(v[a-zA-Z0-9_.-]+)$")
+var usesExpression = regexp.MustCompile("^([a-zA-Z0-9_.-]+)/([a-zA-Z0-9_.-]+)@([0-9a-f]{40}|v[a-zA-Z0-9_.-]+)$")
+
+var shaExpression = regexp.MustCompile("^[0-9a-f]{40}$")
+
+var majorVersionExpression = regexp.MustCompile("^(v[0-9]+)")
+
+func isSHA(ref string) bool {
+	return shaExpression.MatchString(ref)
+}
+
+// majorVersion extracts the leading `vN` major version component from a tag such as `v1.2.3`.
+func majorVersion(tag string) (string, error) {
+	match := majorVersionExpression.FindStringSubmatch(tag)
+	if match == nil {
+		return "", fmt.Errorf("cannot determine major version: %q", tag)
+	}
+
+	return match[1], nil
+}
 
-func replaceUsesVersionTagWithSHA(ctx context.Context, gitHub *github.Client, uses string) (string, error) {
+// splitUses parses a `uses:` value in the form `owner/repo@ref`.
+func splitUses(uses string) (owner, repo, ref string, err error) {
 	matches := usesExpression.FindAllStringSubmatch(uses, 1)
 	if len(matches) != 1 {
-		return "", fmt.Errorf("invalid number of matches: %v", len(matches))
+		return "", "", "", fmt.Errorf("invalid number of matches: %v", len(matches))
 	}
 
 	match := matches[0]
 	if len(match) != 4 {
-		return "", fmt.Errorf("invalid number of submatches: %v", len(match))
+		return "", "", "", fmt.Errorf("invalid number of submatches: %v", len(match))
+	}
+
+	return match[1], match[2], match[3], nil
+}
+
+// resolveLatestCompatibleTag returns the tag name of the most recent release of owner/repo whose
+// tag shares the given major version, e.g. major "v4" matches a release tagged "v4.1.2".
+func resolveLatestCompatibleTag(ctx context.Context, gitHub *github.Client, owner, repo, major string) (string, error) {
+	opt := &github.ListOptions{PerPage: 100}
+
+	for {
+		releases, resp, err := gitHub.Repositories.ListReleases(ctx, owner, repo, opt)
+		if err != nil {
+			return "", fmt.Errorf("error listing releases: %v", err)
+		}
+
+		for _, release := range releases {
+			tag := release.GetTagName()
+			if tag == major || strings.HasPrefix(tag, major+".") {
+				return tag, nil
+			}
+		}
+
+		if resp.NextPage == 0 {
+			break
+		}
+
+		opt.Page = resp.NextPage
 	}
 
-	owner := match[1]
-	repo := match[2]
-	ref := match[3]
+	return "", fmt.Errorf("no release found for major version %q of %s/%s", major, owner, repo)
+}
+
+// replaceUsesVersionTagWithSHA resolves ref to the SHA of the commit it points at and returns the
+// rewritten uses value together with the original ref, so callers can preserve it as a comment.
+func replaceUsesVersionTagWithSHA(ctx context.Context, gitHub *github.Client, uses string) (string, string, error) {
+	owner, repo, ref, err := splitUses(uses)
+	if err != nil {
+		return "", "", err
+	}
 
 	commit, _, err := gitHub.Repositories.GetCommit(ctx, owner, repo, ref, nil)
 	if err != nil {
-		return "", fmt.Errorf("error getting commit: %v", err)
+		return "", "", fmt.Errorf("error getting commit: %v", err)
+	}
+
+	newRef := commit.GetSHA()
+	if newRef == "" {
+		return "", "", errors.New("missing SHA for ref")
+	}
+
+	return fmt.Sprintf("%s/%s@%s", owner, repo, newRef), ref, nil
+}
+
+// updateUsesVersionSHA re-pins an already SHA-pinned uses value to the latest release matching the
+// major version recorded in originalTag, returning the rewritten value and the newly pinned tag.
+func updateUsesVersionSHA(ctx context.Context, gitHub *github.Client, uses, originalTag string) (string, string, error) {
+	owner, repo, ref, err := splitUses(uses)
+	if err != nil {
+		return "", "", err
+	}
+
+	if !isSHA(ref) {
+		return "", "", fmt.Errorf("uses value is not pinned to a SHA: %q", uses)
+	}
+
+	if originalTag == "" {
+		return "", "", fmt.Errorf("missing original tag comment for %q", uses)
+	}
+
+	major, err := majorVersion(originalTag)
+	if err != nil {
+		return "", "", err
+	}
+
+	latestTag, err := resolveLatestCompatibleTag(ctx, gitHub, owner, repo, major)
+	if err != nil {
+		return "", "", err
+	}
+
+	commit, _, err := gitHub.Repositories.GetCommit(ctx, owner, repo, latestTag, nil)
+	if err != nil {
+		return "", "", fmt.Errorf("error getting commit: %v", err)
 	}
 
 	newRef := commit.GetSHA()
 	if newRef == "" {
-		return "", errors.New("missing SHA for ref")
+		return "", "", errors.New("missing SHA for ref")
+	}
+
+	return fmt.Sprintf("%s/%s@%s", owner, repo, newRef), latestTag, nil
+}
+
+// unpinUsesVersionSHA reverses a pin, replacing the SHA in uses with the tag recorded in originalTag.
+func unpinUsesVersionSHA(uses, originalTag string) (string, error) {
+	owner, repo, ref, err := splitUses(uses)
+	if err != nil {
+		return "", err
+	}
+
+	if !isSHA(ref) {
+		return "", fmt.Errorf("uses value is not pinned to a SHA: %q", uses)
+	}
+
+	if originalTag == "" {
+		return "", fmt.Errorf("missing original tag comment for %q", uses)
 	}
 
-	return fmt.Sprintf("%s/%s@%s", owner, repo, newRef), nil
+	return fmt.Sprintf("%s/%s@%s", owner, repo, originalTag), nil
 }
 
-func processYAMLUses(sourceFileName, targetFileName string, processFunc func(string) string) error {
+// isWorkflowDocument reports whether doc's top-level mapping has a `jobs:` or `runs:` key, i.e. it is
+// a workflow or a composite/Docker action rather than some other YAML file living under `.github/`.
+func isWorkflowDocument(doc *yaml.Node) bool {
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return false
+	}
+
+	root := doc.Content[0]
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		switch root.Content[i].Value {
+		case "jobs", "runs":
+			return true
+		}
+	}
+
+	return false
+}
+
+func processYAMLUses(sourceFileName, targetFileName string, processFunc func(valueNode *yaml.Node)) error {
 	data, err := os.ReadFile(sourceFileName)
 	if err != nil {
 		return fmt.Errorf("error reading file: %v", err)
@@ -62,6 +211,10 @@ func processYAMLUses(sourceFileName, targetFileName string, processFunc func(str
 		return fmt.Errorf("error parsing YAML: %v", err)
 	}
 
+	if !isWorkflowDocument(&doc) {
+		return nil
+	}
+
 	modified := processUsesInAST(&doc, processFunc)
 
 	if modified {
@@ -83,7 +236,7 @@ func processYAMLUses(sourceFileName, targetFileName string, processFunc func(str
 	return nil
 }
 
-func processUsesInAST(node *yaml.Node, processFunc func(string) string) bool {
+func processUsesInAST(node *yaml.Node, processFunc func(valueNode *yaml.Node)) bool {
 	modified := false
 
 	for i := 0; i < len(node.Content); i++ {
@@ -93,10 +246,11 @@ func processUsesInAST(node *yaml.Node, processFunc func(string) string) bool {
 			if i+1 < len(node.Content) {
 				valueNode := node.Content[i+1]
 				originalValue := valueNode.Value
-				newValue := processFunc(originalValue)
+				originalComment := valueNode.LineComment
+
+				processFunc(valueNode)
 
-				if newValue != originalValue {
-					valueNode.Value = newValue
+				if valueNode.Value != originalValue || valueNode.LineComment != originalComment {
 					modified = true
 				}
 			}
@@ -112,24 +266,89 @@ func processUsesInAST(node *yaml.Node, processFunc func(string) string) bool {
 	return modified
 }
 
+const (
+	pinMode    = "pin"
+	updateMode = "update"
+	unpinMode  = "unpin"
+)
+
+const defaultPatterns = "**/*.yml,**/*.yaml"
+
+// splitPatterns turns a comma-separated flag value into a slice of trimmed, non-empty glob patterns.
+func splitPatterns(value string) (patterns []string) {
+	for _, pattern := range strings.Split(value, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+
+	return
+}
+
+func validateMode(mode string) string {
+	switch mode {
+	case pinMode, updateMode, unpinMode:
+		return mode
+	}
+
+	log.Fatal("unknown mode")
+
+	return ""
+}
+
 type AppContext struct {
 	gitHub             *github.Client
 	workflowsDirectory string
 	overwriteFiles     bool
+	mode               string
+	includePatterns    []string
+	excludePatterns    []string
 }
 
-func (a *AppContext) IterateWorkflowFiles(ctx context.Context) error {
-	entries, err := os.ReadDir(a.workflowsDirectory)
-	if err != nil {
-		return fmt.Errorf("error reading directory: %v", err)
+// matchesPatterns reports whether relativeFileName (relative to workflowsDirectory, slash-separated)
+// is selected by includePatterns and not rejected by excludePatterns.
+func (a *AppContext) matchesPatterns(relativeFileName string) bool {
+	matched := false
+
+	for _, pattern := range a.includePatterns {
+		if ok, _ := doublestar.Match(pattern, relativeFileName); ok {
+			matched = true
+			break
+		}
+	}
+
+	if !matched {
+		return false
+	}
+
+	for _, pattern := range a.excludePatterns {
+		if ok, _ := doublestar.Match(pattern, relativeFileName); ok {
+			return false
+		}
 	}
 
-	for _, entry := range entries {
+	return true
+}
+
+func (a *AppContext) IterateWorkflowFiles(ctx context.Context) error {
+	return filepath.WalkDir(a.workflowsDirectory, func(sourceFileName string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
 		if entry.IsDir() {
-			continue
+			return nil
+		}
+
+		relativeFileName, err := filepath.Rel(a.workflowsDirectory, sourceFileName)
+		if err != nil {
+			return err
+		}
+
+		if !a.matchesPatterns(filepath.ToSlash(relativeFileName)) {
+			return nil
 		}
 
-		sourceFileName := path.Join(a.workflowsDirectory, entry.Name())
 		targetFileName := os.Stdout.Name()
 
 		if a.overwriteFiles {
@@ -138,35 +357,43 @@ func (a *AppContext) IterateWorkflowFiles(ctx context.Context) error {
 
 		log.Printf("processing %q\n", sourceFileName)
 
-		if err := processYAMLUses(sourceFileName, targetFileName, func(uses string) string {
-			newUses, err := replaceUsesVersionTagWithSHA(ctx, a.gitHub, uses)
-			if err != nil {
-				log.Print(err)
-				return uses
-			}
-
-			return newUses
-		}); err != nil {
-			return err
-		}
-	}
+		return processYAMLUses(sourceFileName, targetFileName, func(valueNode *yaml.Node) {
+			originalTag := strings.TrimPrefix(valueNode.LineComment, "# ")
 
-	return nil
-}
+			switch a.mode {
+			case updateMode:
+				newUses, newTag, err := updateUsesVersionSHA(ctx, a.gitHub, valueNode.Value, originalTag)
+				if err != nil {
+					log.Print(err)
+					return
+				}
 
-func newGitHubClient(authToken string) *github.Client {
-	rateLimit, err := github_ratelimit.NewRateLimitWaiterClient(httpcache.NewTransport(lrucache.New(1000, int64(3600))))
-	if err != nil {
-		log.Fatal(err)
-	}
+				valueNode.Value = newUses
+				valueNode.LineComment = fmt.Sprintf("# %s", newTag)
+			case unpinMode:
+				newUses, err := unpinUsesVersionSHA(valueNode.Value, originalTag)
+				if err != nil {
+					log.Print(err)
+					return
+				}
 
-	client := github.NewClient(rateLimit)
+				valueNode.Value = newUses
+				valueNode.LineComment = ""
+			default:
+				newUses, originalRef, err := replaceUsesVersionTagWithSHA(ctx, a.gitHub, valueNode.Value)
+				if err != nil {
+					log.Print(err)
+					return
+				}
 
-	if authToken != "" {
-		return client.WithAuthToken(authToken)
-	}
+				valueNode.Value = newUses
 
-	return client
+				if !isSHA(originalRef) {
+					valueNode.LineComment = fmt.Sprintf("# %s", originalRef)
+				}
+			}
+		})
+	})
 }
 
 func main() {
@@ -174,6 +401,13 @@ func main() {
 
 	workflowsDirectory := flag.String("workflows-directory", "", "The directory that contains your GitHub workflow YAML files.")
 	overwriteFiles := flag.Bool("overwrite-files", false, "Overwrite the workflow files.")
+	mode := flag.String("mode", pinMode, fmt.Sprintf("Desired operation: %q, %q or %q", pinMode, updateMode, unpinMode))
+	include := flag.String("include", defaultPatterns, "Comma-separated doublestar glob patterns (relative to -workflows-directory) of files to process.")
+	exclude := flag.String("exclude", "", "Comma-separated doublestar glob patterns (relative to -workflows-directory) of files to skip.")
+	appID := flag.Int64("app-id", 0, "Authenticate as this GitHub App ID instead of using GITHUB_TOKEN")
+	appPrivateKeyFile := flag.String("app-private-key-file", "", "Path to the GitHub App's private key, required with -app-id")
+	installationID := flag.Int64("installation-id", 0, "The GitHub App installation ID to act as, required with -app-id")
+	githubBaseURL := flag.String("github-base-url", "", "Base URL of a GitHub Enterprise Server instance, if not using github.com")
 
 	flag.Parse()
 
@@ -181,10 +415,27 @@ func main() {
 		log.Fatal("-workflows-directory is required")
 	}
 
+	gitHub, err := ghclient.New(ghclient.Options{
+		AuthToken:         authToken,
+		AppID:             *appID,
+		AppPrivateKeyFile: *appPrivateKeyFile,
+		InstallationID:    *installationID,
+		BaseURL:           *githubBaseURL,
+		EnableCache:       true,
+		CacheCapacity:     1000,
+		CacheMaxAgeSec:    3600,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	appContext := AppContext{
-		gitHub:             newGitHubClient(authToken),
+		gitHub:             gitHub,
 		workflowsDirectory: *workflowsDirectory,
 		overwriteFiles:     *overwriteFiles,
+		mode:               validateMode(*mode),
+		includePatterns:    splitPatterns(*include),
+		excludePatterns:    splitPatterns(*exclude),
 	}
 
 	ctx := context.Background()
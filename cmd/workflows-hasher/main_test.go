@@ -0,0 +1,172 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-github/v70/github"
+	"gopkg.in/yaml.v3"
+)
+
+func TestMajorVersion(t *testing.T) {
+	tests := []struct {
+		tag     string
+		want    string
+		wantErr bool
+	}{
+		{tag: "v1.2.3", want: "v1"},
+		{tag: "v10.2.0", want: "v10"},
+		{tag: "v4", want: "v4"},
+		{tag: "not-a-version", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := majorVersion(tt.tag)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("majorVersion(%q) = %q, want error", tt.tag, got)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("majorVersion(%q) returned error: %v", tt.tag, err)
+		}
+		if got != tt.want {
+			t.Errorf("majorVersion(%q) = %q, want %q", tt.tag, got, tt.want)
+		}
+	}
+}
+
+// newTestClient returns a github.Client backed by a test server serving releases.
+func newTestClient(t *testing.T, tags []string) *github.Client {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/owner/repo/releases", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("["))
+		for i, tag := range tags {
+			if i > 0 {
+				w.Write([]byte(","))
+			}
+			w.Write([]byte(`{"tag_name":"` + tag + `"}`))
+		}
+		w.Write([]byte("]"))
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	client := github.NewClient(nil)
+	baseURL, _ := url.Parse(server.URL + "/")
+	client.BaseURL = baseURL
+
+	return client
+}
+
+func TestResolveLatestCompatibleTag(t *testing.T) {
+	tests := []struct {
+		name    string
+		tags    []string
+		major   string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "matches exact major",
+			tags:  []string{"v10.2.0", "v1.3.0", "v1.2.0"},
+			major: "v1",
+			want:  "v1.3.0",
+		},
+		{
+			name:  "does not match longer major as prefix",
+			tags:  []string{"v100.0.0", "v11.0.0", "v10.0.0"},
+			major: "v1",
+			want:  "",
+		},
+		{
+			name:  "matches bare major tag",
+			tags:  []string{"v4"},
+			major: "v4",
+			want:  "v4",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestClient(t, tt.tags)
+
+			got, err := resolveLatestCompatibleTag(t.Context(), client, "owner", "repo", tt.major)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("resolveLatestCompatibleTag() = %q, want error", got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("resolveLatestCompatibleTag() returned error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("resolveLatestCompatibleTag() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func marshalUses(t *testing.T, uses string) *yaml.Node {
+	t.Helper()
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte("jobs:\n  build:\n    steps:\n      - uses: "+uses+"\n"), &doc); err != nil {
+		t.Fatalf("yaml.Unmarshal() returned error: %v", err)
+	}
+
+	return &doc
+}
+
+func TestUnpinUsesVersionSHA(t *testing.T) {
+	newUses, err := unpinUsesVersionSHA("owner/repo@"+"0123456789012345678901234567890123456789", "v1.2.3")
+	if err != nil {
+		t.Fatalf("unpinUsesVersionSHA() returned error: %v", err)
+	}
+	if want := "owner/repo@v1.2.3"; newUses != want {
+		t.Errorf("unpinUsesVersionSHA() = %q, want %q", newUses, want)
+	}
+}
+
+func TestProcessUsesInASTRoundTrip(t *testing.T) {
+	sha := "0123456789012345678901234567890123456789"
+	doc := marshalUses(t, "owner/repo@"+sha)
+
+	var seenComment string
+
+	processUsesInAST(doc, func(valueNode *yaml.Node) {
+		seenComment = valueNode.LineComment
+
+		newUses, err := unpinUsesVersionSHA(valueNode.Value, "v1.2.3")
+		if err != nil {
+			t.Fatalf("unpinUsesVersionSHA() returned error: %v", err)
+		}
+
+		valueNode.Value = newUses
+		valueNode.LineComment = ""
+	})
+
+	if seenComment != "" {
+		t.Errorf("unexpected pre-existing comment: %q", seenComment)
+	}
+
+	out, err := yaml.Marshal(doc)
+	if err != nil {
+		t.Fatalf("yaml.Marshal() returned error: %v", err)
+	}
+
+	want := "jobs:\n    build:\n        steps:\n            - uses: owner/repo@v1.2.3\n"
+	if string(out) != want {
+		t.Errorf("round-tripped YAML = %q, want %q", out, want)
+	}
+}
@@ -1,5 +1,5 @@
-// Musikant (German word for *musician*) adds the `hacktoberfest` topic to all of your public GitHub repositories,
-// excluding forks and archived repositories.
+// Musikant (German word for *musician*) adds a topic, `hacktoberfest` by default, to all of your public GitHub
+// repositories, excluding forks and archived repositories.
 //
 // You have to provide an environment variable called `GITHUB_TOKEN` which contains
 // a [personal access token](https://github.com/settings/personal-access-tokens/new).
@@ -10,79 +10,267 @@
 // | Administration        | Read and write |
 // | Metadata              | Read-only      |
 //
-// Use `-mode remove` to remove the `hacktoberfest` topic again.
+// Use `-topic` to target a different topic, and `-mode remove` to remove it again.
+//
+// By default, only the repositories owned by the authenticated user are considered. Use `-owners` with a
+// comma-separated list of user or organization logins to process the repositories owned by those accounts
+// instead, and `-include-collaborator` to also include repositories the authenticated user merely collaborates on.
+//
+// Selection can be narrowed further with `-match-topic` (repos must already carry these topics),
+// `-match-language`, `-min-stars`, and `-exclude-repo` (a comma-separated "owner/name" denylist).
 //
 // If you don't want to make changes right away, use `-dry-run`.
 //
-// Note: GitHub's repo topics API is not transactional.
+// Note: GitHub's repo topics API is not transactional. Pass `-state-file` with a path to snapshot
+// every selected repo's original topics before making any changes; an interrupted run can then be
+// resumed by rerunning the same command, since repos already recorded as done are skipped. Use
+// `-rollback` together with the same `-state-file` to restore the original topics it recorded.
+//
+// Personal access tokens can't cleanly administer repositories owned by an organization that
+// restricts PAT access. Use `-app-id`, `-app-private-key-file` and `-installation-id` to authenticate
+// as a GitHub App installation instead of relying on `GITHUB_TOKEN`.
 package main
 
 import (
 	"context"
 	"flag"
 	"fmt"
-	"github.com/gofri/go-github-ratelimit/github_ratelimit"
-	"github.com/google/go-github/v55/github"
 	"log"
-	"net/http"
 	"os"
 	"runtime"
 	"slices"
 	"strings"
 	"sync"
+
+	"github.com/google/go-github/v70/github"
+
+	"go.eigsys.de/musikant/internal/ghclient"
 )
 
 type AppContext struct {
-	gitHub          *github.Client
-	isAddMode       bool
-	isDryRun        bool
-	maxReposPerPage int
-	maxPages        int
-	maxWorkers      int
-	affectedTopic   string
+	gitHub              *github.Client
+	isAddMode           bool
+	isDryRun            bool
+	maxReposPerPage     int
+	maxPages            int
+	maxWorkers          int
+	topic               string
+	owners              []string
+	includeCollaborator bool
+	matchTopics         []string
+	matchLanguage       string
+	minStars            int
+	excludeRepos        []string
+	snapshot            *Snapshot
 }
 
-func (a *AppContext) getRepos() (allRepos []*github.Repository) {
-	opt := &github.RepositoryListOptions{
-		Visibility:  "public",
-		Affiliation: "owner",
-		ListOptions: github.ListOptions{PerPage: a.maxReposPerPage},
+// repoFullName returns repo's "owner/name" identifier, as used to key the snapshot.
+func repoFullName(repo *github.Repository) (string, bool) {
+	if repo.Owner == nil || repo.Owner.Login == nil || repo.Name == nil {
+		return "", false
+	}
+
+	return fmt.Sprintf("%s/%s", *repo.Owner.Login, *repo.Name), true
+}
+
+// splitRepoFullName splits a snapshot key back into owner and repo name.
+func splitRepoFullName(fullName string) (owner, name string, ok bool) {
+	parts := strings.SplitN(fullName, "/", 2)
+	if len(parts) != 2 {
+		return "", "", false
 	}
 
+	return parts[0], parts[1], true
+}
+
+// isSelectedRepo reports whether repo is a candidate for the current mode: not a fork or archived,
+// not excluded, matching the configured topics/language/star criteria, and missing the target topic
+// in add mode or carrying it in remove mode.
+func (a *AppContext) isSelectedRepo(repo *github.Repository) bool {
+	if repo.Fork == nil || *repo.Fork {
+		return false
+	}
+
+	if repo.Archived == nil || *repo.Archived {
+		return false
+	}
+
+	if a.isExcludedRepo(repo) {
+		return false
+	}
+
+	if !hasAllTopics(repo, a.matchTopics) {
+		return false
+	}
+
+	if a.matchLanguage != "" && !strings.EqualFold(repo.GetLanguage(), a.matchLanguage) {
+		return false
+	}
+
+	if repo.GetStargazersCount() < a.minStars {
+		return false
+	}
+
+	hasTargetTopic := hasTopic(repo, a.topic)
+
+	return hasTargetTopic != a.isAddMode
+}
+
+// isExcludedRepo reports whether repo's "owner/name" full name is listed in a.excludeRepos.
+func (a *AppContext) isExcludedRepo(repo *github.Repository) bool {
+	fullName, ok := repoFullName(repo)
+	if !ok {
+		return false
+	}
+
+	return slices.Contains(a.excludeRepos, fullName)
+}
+
+// hasAllTopics reports whether repo carries every one of the given topics.
+func hasAllTopics(repo *github.Repository, topics []string) bool {
+	for _, topic := range topics {
+		if !hasTopic(repo, topic) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// paginate drives fetchPage, which receives a page number and returns that page's repos together with
+// the next page number (0 if there is none), up to a.maxPages.
+func (a *AppContext) paginate(fetchPage func(page int) ([]*github.Repository, int, error)) (allRepos []*github.Repository) {
+	page := 0
+
 	for i := 0; i < a.maxPages; i++ {
-		repos, resp, err := a.gitHub.Repositories.List(context.Background(), "", opt)
+		repos, nextPage, err := fetchPage(page)
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		for _, repo := range repos {
-			if repo.Fork == nil || *repo.Fork {
-				continue
-			}
+		allRepos = append(allRepos, repos...)
 
-			if repo.Archived == nil || *repo.Archived {
-				continue
-			}
+		if nextPage == 0 {
+			break
+		}
+
+		page = nextPage
+	}
+
+	return
+}
+
+// isOrganization determines whether owner is a GitHub organization rather than a user account.
+func (a *AppContext) isOrganization(ctx context.Context, owner string) bool {
+	user, _, err := a.gitHub.Users.Get(ctx, owner)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-			hasAffectedTopic := hasTopic(repo, a.affectedTopic)
+	return user.GetType() == "Organization"
+}
+
+// listReposByAffiliation lists public repositories of the authenticated user for the given affiliation,
+// e.g. "owner" or "collaborator".
+func (a *AppContext) listReposByAffiliation(ctx context.Context, affiliation string) []*github.Repository {
+	return a.paginate(func(page int) ([]*github.Repository, int, error) {
+		opt := &github.RepositoryListOptions{
+			Visibility:  "public",
+			Affiliation: affiliation,
+			ListOptions: github.ListOptions{PerPage: a.maxReposPerPage, Page: page},
+		}
+
+		repos, resp, err := a.gitHub.Repositories.List(ctx, "", opt)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		return repos, resp.NextPage, nil
+	})
+}
 
-			if !hasAffectedTopic && a.isAddMode {
-				allRepos = append(allRepos, repo)
+// listReposForOwner lists public repositories owned by owner, dispatching to the organization or user
+// endpoint depending on the account type.
+func (a *AppContext) listReposForOwner(ctx context.Context, owner string) []*github.Repository {
+	if a.isOrganization(ctx, owner) {
+		return a.paginate(func(page int) ([]*github.Repository, int, error) {
+			opt := &github.RepositoryListByOrgOptions{
+				Type:        "public",
+				ListOptions: github.ListOptions{PerPage: a.maxReposPerPage, Page: page},
 			}
 
-			if hasAffectedTopic && !a.isAddMode {
-				allRepos = append(allRepos, repo)
+			repos, resp, err := a.gitHub.Repositories.ListByOrg(ctx, owner, opt)
+			if err != nil {
+				return nil, 0, err
 			}
+
+			return repos, resp.NextPage, nil
+		})
+	}
+
+	return a.paginate(func(page int) ([]*github.Repository, int, error) {
+		opt := &github.RepositoryListOptions{
+			Visibility:  "public",
+			ListOptions: github.ListOptions{PerPage: a.maxReposPerPage, Page: page},
 		}
 
-		if resp.NextPage == 0 {
-			break
+		repos, resp, err := a.gitHub.Repositories.List(ctx, owner, opt)
+		if err != nil {
+			return nil, 0, err
 		}
 
-		opt.Page = resp.NextPage
+		return repos, resp.NextPage, nil
+	})
+}
+
+func (a *AppContext) getRepos() (allRepos []*github.Repository) {
+	ctx := context.Background()
+
+	if len(a.owners) == 0 {
+		allRepos = append(allRepos, a.listReposByAffiliation(ctx, "owner")...)
 	}
 
-	return
+	for _, owner := range a.owners {
+		allRepos = append(allRepos, a.listReposForOwner(ctx, owner)...)
+	}
+
+	if a.includeCollaborator {
+		allRepos = append(allRepos, a.listReposByAffiliation(ctx, "collaborator")...)
+	}
+
+	var selectedRepos []*github.Repository
+
+	for _, repo := range allRepos {
+		if !a.isSelectedRepo(repo) {
+			continue
+		}
+
+		if a.snapshot != nil {
+			if fullName, ok := repoFullName(repo); ok && a.snapshot.isDone(fullName) {
+				continue
+			}
+		}
+
+		selectedRepos = append(selectedRepos, repo)
+	}
+
+	return selectedRepos
+}
+
+// prepareSnapshot stages the original topics of repos into a.snapshot, if one is configured, and
+// persists it before any of them are dispatched for modification.
+func (a *AppContext) prepareSnapshot(repos []*github.Repository) error {
+	if a.snapshot == nil {
+		return nil
+	}
+
+	for _, repo := range repos {
+		if fullName, ok := repoFullName(repo); ok {
+			a.snapshot.stage(fullName, repo.Topics)
+		}
+	}
+
+	return a.snapshot.save()
 }
 
 func (a *AppContext) updateTopicsOfRepos(repos []*github.Repository) {
@@ -113,14 +301,15 @@ func (a *AppContext) updateTopicsOfRepo(repo *github.Repository) {
 	var newTopics []string
 
 	if a.isAddMode {
-		newTopics = append(repo.Topics, a.affectedTopic)
+		newTopics = append(repo.Topics, a.topic)
 	}
 
 	if !a.isAddMode {
-		newTopics = removeTopic(repo.Topics, a.affectedTopic)
+		newTopics = removeTopic(repo.Topics, a.topic)
 	}
 
-	if repo.Owner == nil || repo.Owner.Login == nil || repo.Name == nil {
+	fullName, ok := repoFullName(repo)
+	if !ok {
 		log.Fatal("invalid owner, owner login or repo name")
 	}
 
@@ -137,11 +326,68 @@ func (a *AppContext) updateTopicsOfRepo(repo *github.Repository) {
 	)
 	if err != nil {
 		log.Printf("%q failed: %s", *repo.Name, err)
-	} else {
-		log.Printf("%q updated: %s", *repo.Name, strings.Join(confirmedTopics, " "))
+		return
+	}
+
+	log.Printf("%q updated: %s", *repo.Name, strings.Join(confirmedTopics, " "))
+
+	if a.snapshot != nil {
+		if err := a.snapshot.markDone(fullName); err != nil {
+			log.Printf("%q: error updating state file: %s", *repo.Name, err)
+		}
 	}
 }
 
+// rollback restores the topics recorded in a.snapshot for every repo it covers, undoing a previous run.
+func (a *AppContext) rollback() {
+	fullNames := a.snapshot.fullNames()
+
+	jobs := make(chan string, len(fullNames))
+	var wg sync.WaitGroup
+
+	for i := 0; i < a.maxWorkers; i++ {
+		wg.Add(1)
+
+		go func(jobs <-chan string) {
+			defer wg.Done()
+
+			for fullName := range jobs {
+				a.rollbackRepo(fullName)
+			}
+		}(jobs)
+	}
+
+	for _, fullName := range fullNames {
+		jobs <- fullName
+	}
+
+	close(jobs)
+	wg.Wait()
+}
+
+func (a *AppContext) rollbackRepo(fullName string) {
+	owner, name, ok := splitRepoFullName(fullName)
+	if !ok {
+		log.Printf("%q: invalid owner/name in state file", fullName)
+		return
+	}
+
+	topics, _ := a.snapshot.originalTopics(fullName)
+
+	if a.isDryRun {
+		log.Printf("%q not rolled back (dry run): %s", name, strings.Join(topics, " "))
+		return
+	}
+
+	confirmedTopics, _, err := a.gitHub.Repositories.ReplaceAllTopics(context.Background(), owner, name, topics)
+	if err != nil {
+		log.Printf("%q rollback failed: %s", name, err)
+		return
+	}
+
+	log.Printf("%q rolled back: %s", name, strings.Join(confirmedTopics, " "))
+}
+
 func mapRepoNames(repos []*github.Repository) []string {
 	repoNames := make([]string, len(repos))
 	for i := range repos {
@@ -184,13 +430,15 @@ func isAddMode(mode string) bool {
 	return false
 }
 
-func newGitHubClient() *github.Client {
-	rateLimit, err := github_ratelimit.NewRateLimitWaiterClient(http.DefaultTransport)
-	if err != nil {
-		log.Fatal(err)
+// splitList turns a comma-separated flag value into a slice of trimmed, non-empty elements.
+func splitList(value string) (elements []string) {
+	for _, element := range strings.Split(value, ",") {
+		if element = strings.TrimSpace(element); element != "" {
+			elements = append(elements, element)
+		}
 	}
 
-	return github.NewClient(rateLimit)
+	return
 }
 
 func main() {
@@ -199,21 +447,77 @@ func main() {
 	mode := flag.String("mode", addMode, fmt.Sprintf("Desired operation: %q or %q", addMode, removeMode))
 	dryRun := flag.Bool("dry-run", false, "Don't make any changes")
 	maxWorkers := flag.Int("max-workers", runtime.NumCPU(), "Maximum number of concurrent requests to GitHub API")
+	owners := flag.String("owners", "", "Comma-separated logins of users or organizations to process. Defaults to the authenticated user's own repositories.")
+	includeCollaborator := flag.Bool("include-collaborator", false, "Also include repositories the authenticated user has collaborator access to")
+	topic := flag.String("topic", "hacktoberfest", "The topic to add or remove")
+	matchTopic := flag.String("match-topic", "", "Comma-separated topics a repo must already carry to be considered")
+	matchLanguage := flag.String("match-language", "", "Only consider repos whose primary language matches this")
+	minStars := flag.Int("min-stars", 0, "Only consider repos with at least this many stars")
+	excludeRepo := flag.String("exclude-repo", "", "Comma-separated \"owner/name\" repos to never touch")
+	stateFile := flag.String("state-file", "", "Path to a JSON file recording original topics, enabling -rollback and resuming an interrupted run")
+	rollback := flag.Bool("rollback", false, "Restore the original topics recorded in -state-file instead of making changes")
+	appID := flag.Int64("app-id", 0, "Authenticate as this GitHub App ID instead of using GITHUB_TOKEN")
+	appPrivateKeyFile := flag.String("app-private-key-file", "", "Path to the GitHub App's private key, required with -app-id")
+	installationID := flag.Int64("installation-id", 0, "The GitHub App installation ID to act as, required with -app-id")
+	githubBaseURL := flag.String("github-base-url", "", "Base URL of a GitHub Enterprise Server instance, if not using github.com")
 
 	flag.Parse()
 
+	var snapshot *Snapshot
+
+	if *stateFile != "" {
+		var err error
+
+		snapshot, err = loadSnapshot(*stateFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	gitHub, err := ghclient.New(ghclient.Options{
+		AuthToken:         authToken,
+		AppID:             *appID,
+		AppPrivateKeyFile: *appPrivateKeyFile,
+		InstallationID:    *installationID,
+		BaseURL:           *githubBaseURL,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	appContext := AppContext{
-		gitHub:          newGitHubClient().WithAuthToken(authToken),
-		isAddMode:       isAddMode(*mode),
-		isDryRun:        *dryRun,
-		maxReposPerPage: 50,
-		maxPages:        25,
-		maxWorkers:      *maxWorkers,
-		affectedTopic:   "hacktoberfest",
+		gitHub:              gitHub,
+		isAddMode:           isAddMode(*mode),
+		isDryRun:            *dryRun,
+		maxReposPerPage:     50,
+		maxPages:            25,
+		maxWorkers:          *maxWorkers,
+		topic:               *topic,
+		owners:              splitList(*owners),
+		includeCollaborator: *includeCollaborator,
+		matchTopics:         splitList(*matchTopic),
+		matchLanguage:       *matchLanguage,
+		minStars:            *minStars,
+		excludeRepos:        splitList(*excludeRepo),
+		snapshot:            snapshot,
+	}
+
+	if *rollback {
+		if snapshot == nil {
+			log.Fatal("-rollback requires -state-file")
+		}
+
+		appContext.rollback()
+
+		return
 	}
 
 	repos := appContext.getRepos()
-	log.Printf("Changing the topic %q for the following repos: %s", appContext.affectedTopic, strings.Join(mapRepoNames(repos), " "))
+	log.Printf("Changing the topic %q for the following repos: %s", appContext.topic, strings.Join(mapRepoNames(repos), " "))
+
+	if err := appContext.prepareSnapshot(repos); err != nil {
+		log.Fatal(err)
+	}
 
 	appContext.updateTopicsOfRepos(repos)
 }
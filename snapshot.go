@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// SnapshotEntry records a repo's topics as they were before the run started, and whether the
+// corresponding ReplaceAllTopics call has already completed successfully.
+type SnapshotEntry struct {
+	Topics []string `json:"topics"`
+	Done   bool     `json:"done"`
+}
+
+// Snapshot is a resumable, on-disk record of a bulk topic update, keyed by "owner/name". GitHub's
+// topics API is not transactional, so a crash partway through a run must be able to resume without
+// re-touching repos that already succeeded, and a completed run must be reversible.
+type Snapshot struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[string]*SnapshotEntry
+}
+
+// loadSnapshot reads an existing snapshot from path, or returns an empty one if it doesn't exist yet.
+func loadSnapshot(path string) (*Snapshot, error) {
+	snapshot := &Snapshot{path: path, entries: make(map[string]*SnapshotEntry)}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return snapshot, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading state file: %v", err)
+	}
+
+	if err := json.Unmarshal(data, &snapshot.entries); err != nil {
+		return nil, fmt.Errorf("error parsing state file: %v", err)
+	}
+
+	return snapshot, nil
+}
+
+// save writes the snapshot to path, replacing any existing file atomically so a crash mid-write
+// can never leave a truncated or corrupt state file behind.
+func (s *Snapshot) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := json.MarshalIndent(s.entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling state file: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("error creating temporary state file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing temporary state file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing temporary state file: %v", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return fmt.Errorf("error setting state file permissions: %v", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("error replacing state file: %v", err)
+	}
+
+	return nil
+}
+
+// stage registers fullName's original topics, unless it is already known from a previous run.
+func (s *Snapshot) stage(fullName string, topics []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.entries[fullName]; !ok {
+		s.entries[fullName] = &SnapshotEntry{Topics: topics}
+	}
+}
+
+// isDone reports whether fullName has already been processed successfully in a previous run.
+func (s *Snapshot) isDone(fullName string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[fullName]
+
+	return ok && entry.Done
+}
+
+// markDone flags fullName as completed and persists the snapshot.
+func (s *Snapshot) markDone(fullName string) error {
+	s.mu.Lock()
+	entry, ok := s.entries[fullName]
+	if ok {
+		entry.Done = true
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("no snapshot entry for %q", fullName)
+	}
+
+	return s.save()
+}
+
+// originalTopics returns the topics fullName carried before the run started.
+func (s *Snapshot) originalTopics(fullName string) ([]string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[fullName]
+	if !ok {
+		return nil, false
+	}
+
+	return entry.Topics, true
+}
+
+// fullNames returns the "owner/name" keys recorded in the snapshot.
+func (s *Snapshot) fullNames() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fullNames := make([]string, 0, len(s.entries))
+	for fullName := range s.entries {
+		fullNames = append(fullNames, fullName)
+	}
+
+	return fullNames
+}
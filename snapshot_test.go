@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshotStageMarkDone(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	snapshot, err := loadSnapshot(path)
+	if err != nil {
+		t.Fatalf("loadSnapshot() returned error: %v", err)
+	}
+
+	snapshot.stage("owner/repo", []string{"go", "cli"})
+
+	if snapshot.isDone("owner/repo") {
+		t.Fatal("isDone() = true before markDone()")
+	}
+
+	if _, ok := snapshot.originalTopics("missing/repo"); ok {
+		t.Fatal("originalTopics() reported ok for an unstaged repo")
+	}
+
+	topics, ok := snapshot.originalTopics("owner/repo")
+	if !ok || !slicesEqual(topics, []string{"go", "cli"}) {
+		t.Fatalf("originalTopics() = %v, %v, want [go cli], true", topics, ok)
+	}
+
+	// Staging an already-known repo must not overwrite its recorded original topics.
+	snapshot.stage("owner/repo", []string{"different"})
+	if topics, _ := snapshot.originalTopics("owner/repo"); !slicesEqual(topics, []string{"go", "cli"}) {
+		t.Fatalf("stage() overwrote existing entry, got %v", topics)
+	}
+
+	if err := snapshot.markDone("owner/repo"); err != nil {
+		t.Fatalf("markDone() returned error: %v", err)
+	}
+
+	if !snapshot.isDone("owner/repo") {
+		t.Fatal("isDone() = false after markDone()")
+	}
+
+	if err := snapshot.markDone("never/staged"); err == nil {
+		t.Fatal("markDone() on an unstaged repo did not return an error")
+	}
+}
+
+func TestSnapshotSaveLoadRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	snapshot, err := loadSnapshot(path)
+	if err != nil {
+		t.Fatalf("loadSnapshot() returned error: %v", err)
+	}
+
+	snapshot.stage("owner/repo", []string{"go"})
+	if err := snapshot.markDone("owner/repo"); err != nil {
+		t.Fatalf("markDone() returned error: %v", err)
+	}
+
+	reloaded, err := loadSnapshot(path)
+	if err != nil {
+		t.Fatalf("loadSnapshot() returned error: %v", err)
+	}
+
+	if !reloaded.isDone("owner/repo") {
+		t.Fatal("reloaded snapshot does not report owner/repo as done")
+	}
+
+	topics, ok := reloaded.originalTopics("owner/repo")
+	if !ok || !slicesEqual(topics, []string{"go"}) {
+		t.Fatalf("reloaded originalTopics() = %v, %v, want [go], true", topics, ok)
+	}
+
+	if got := reloaded.fullNames(); len(got) != 1 || got[0] != "owner/repo" {
+		t.Fatalf("fullNames() = %v, want [owner/repo]", got)
+	}
+}
+
+func TestLoadSnapshotMissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "does-not-exist.json")
+
+	snapshot, err := loadSnapshot(path)
+	if err != nil {
+		t.Fatalf("loadSnapshot() returned error: %v", err)
+	}
+
+	if len(snapshot.fullNames()) != 0 {
+		t.Fatalf("fullNames() = %v, want empty", snapshot.fullNames())
+	}
+}
+
+func TestLoadSnapshotCorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	if err := os.WriteFile(path, []byte("not json"), 0o644); err != nil {
+		t.Fatalf("os.WriteFile() returned error: %v", err)
+	}
+
+	if _, err := loadSnapshot(path); err == nil {
+		t.Fatal("loadSnapshot() on a corrupt file did not return an error")
+	}
+}
+
+func slicesEqual(a, b []string) bool {
+	encodedA, _ := json.Marshal(a)
+	encodedB, _ := json.Marshal(b)
+
+	return string(encodedA) == string(encodedB)
+}